@@ -17,70 +17,120 @@ limitations under the License.
 package remoteclusters
 
 import (
+	"bytes"
 	"context"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/goph/emperror"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
-	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	istiov1beta1 "github.com/banzaicloud/istio-operator/pkg/apis/istio/v1beta1"
-	"github.com/banzaicloud/istio-operator/pkg/controller/meshgateway"
+	"github.com/banzaicloud/istio-operator/pkg/remoteclusters/pipeline"
 )
 
+// fieldManager identifies this controller's ownership of the fields it
+// sets via server-side apply. The pipeline package applies with Force, so
+// fields that drifted or that another field manager owns are taken back.
+const fieldManager = "istio-operator-remotecluster"
+
 type Cluster struct {
-	name   string
-	config []byte
-	log    logr.Logger
+	name           string
+	configProvider ConfigProvider
+	log            logr.Logger
+
+	mu sync.Mutex
 
 	stop          <-chan struct{}
 	stopper       chan<- struct{}
 	initClient    sync.Once
 	initInformers sync.Once
 
-	mgr manager.Manager
+	cl       cluster.Cluster
+	provider Provider
+	recorder record.EventRecorder
+
+	statusMu            sync.RWMutex
+	status              ClusterStatus
+	backoff             backoffState
+	healthCheckInterval time.Duration
 
 	restConfig        *rest.Config
 	ctrlRuntimeClient client.Client
 	dynamicClient     dynamic.Interface
 	istioConfig       *istiov1beta1.Istio
-	remoteConfig      *istiov1beta1.RemoteIstio
 	ctrl              controller.Controller
+
+	remoteConfigMu sync.RWMutex
+	remoteConfig   *istiov1beta1.RemoteIstio
 }
 
-func NewCluster(name string, ctrl controller.Controller, config []byte, log logr.Logger) (*Cluster, error) {
+// setRemoteConfig records the RemoteIstio CR this cluster is being
+// reconciled for. It's read by the background health-check goroutine (to
+// record Events against the CR) as well as the reconcile goroutine, so
+// access goes through remoteConfigMu rather than a bare field.
+func (c *Cluster) setRemoteConfig(remoteConfig *istiov1beta1.RemoteIstio) {
+	c.remoteConfigMu.Lock()
+	defer c.remoteConfigMu.Unlock()
+
+	c.remoteConfig = remoteConfig
+}
+
+func (c *Cluster) getRemoteConfig() *istiov1beta1.RemoteIstio {
+	c.remoteConfigMu.RLock()
+	defer c.remoteConfigMu.RUnlock()
+
+	return c.remoteConfig
+}
+
+func NewCluster(name string, ctrl controller.Controller, provider Provider, recorder record.EventRecorder, configProvider ConfigProvider, log logr.Logger) (*Cluster, error) {
 	stop := make(chan struct{})
 
-	cluster := &Cluster{
-		name:    name,
-		config:  config,
-		log:     log.WithValues("cluster", name),
-		stop:    stop,
-		stopper: stop,
-		ctrl:    ctrl,
+	if provider == nil {
+		provider = NewMeshGatewayProvider(ctrl, log)
+	}
+
+	c := &Cluster{
+		name:           name,
+		configProvider: configProvider,
+		log:            log.WithValues("cluster", name),
+		stop:           stop,
+		stopper:        stop,
+		ctrl:           ctrl,
+		provider:       provider,
+		recorder:       recorder,
 	}
 
-	restConfig, err := cluster.getRestConfig(config)
+	restConfig, err := configProvider.GetRestConfig()
 	if err != nil {
 		return nil, emperror.Wrap(err, "could not get k8s rest config")
 	}
-	cluster.restConfig = restConfig
+	c.restConfig = restConfig
+
+	if err := c.StartHealthCheck(defaultHealthCheckInterval); err != nil {
+		return nil, emperror.Wrap(err, "could not start health check")
+	}
 
-	return cluster, nil
+	return c, nil
 }
 
 func (c *Cluster) GetName() string {
@@ -88,11 +138,12 @@ func (c *Cluster) GetName() string {
 }
 
 func (c *Cluster) initK8sInformers() error {
-	if c.remoteConfig == nil {
+	remoteConfig := c.getRemoteConfig()
+	if remoteConfig == nil {
 		return errors.New("remoteconfig must be set")
 	}
 
-	informer, err := c.mgr.GetCache().GetInformerForKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
+	informer, err := c.cl.GetCache().GetInformerForKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
 	if err != nil {
 		return emperror.Wrap(err, "could not get informer for namespaces")
 	}
@@ -104,8 +155,8 @@ func (c *Cluster) initK8sInformers() error {
 			return []reconcile.Request{
 				{
 					NamespacedName: types.NamespacedName{
-						Name:      c.remoteConfig.Name,
-						Namespace: c.remoteConfig.Namespace,
+						Name:      remoteConfig.Name,
+						Namespace: remoteConfig.Namespace,
 					},
 				},
 			}
@@ -133,15 +184,22 @@ func (c *Cluster) initK8sInformers() error {
 }
 
 func (c *Cluster) initK8SClients() error {
-	err := c.startManager(c.restConfig)
+	err := c.startCluster(c.restConfig)
 	if err != nil {
 		return err
 	}
 
-	// add mesh gateway controller to the manager
-	meshgateway.Add(c.mgr)
+	remoteConfig := c.getRemoteConfig()
+	if remoteConfig == nil {
+		return errors.New("remoteconfig must be set")
+	}
 
-	c.ctrlRuntimeClient = c.mgr.GetClient()
+	remoteIstio := types.NamespacedName{Name: remoteConfig.Name, Namespace: remoteConfig.Namespace}
+	if err := c.provider.Engage(context.Background(), c.name, remoteIstio, c.cl); err != nil {
+		return emperror.Wrap(err, "could not engage cluster with controllers")
+	}
+
+	c.ctrlRuntimeClient = c.cl.GetClient()
 
 	dynamicClient, err := dynamic.NewForConfig(c.restConfig)
 	if err != nil {
@@ -152,17 +210,79 @@ func (c *Cluster) initK8SClients() error {
 	return nil
 }
 
-func (c *Cluster) Reconcile(remoteConfig *istiov1beta1.RemoteIstio, istio *istiov1beta1.Istio) error {
+func (c *Cluster) Reconcile(remoteConfig *istiov1beta1.RemoteIstio, istio *istiov1beta1.Istio) (err error) {
+	if !c.backoff.ready() {
+		return errors.New("skipping reconcile, remote cluster is backed off after repeated failures")
+	}
+
 	c.log.Info("reconciling remote istio")
 
-	var ReconcilerFuncs []func(remoteConfig *istiov1beta1.RemoteIstio, istio *istiov1beta1.Istio) error
+	start := time.Now()
+	var resourceStatuses []pipeline.ObjectStatus
+	defer func() {
+		remoteClusterReconcileDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+
+		c.statusMu.Lock()
+		wasDegraded := c.status.Degraded
+		if err != nil {
+			c.status.LastReconcileError = err.Error()
+			c.status.Degraded = true
+		} else {
+			c.status.LastReconcileError = ""
+			c.status.LastReconcileTime = metav1.Now()
+			c.status.Degraded = false
+		}
+		degraded := c.status.Degraded
+		degradedTransitioned := wasDegraded != degraded
+		c.statusMu.Unlock()
+
+		if err != nil {
+			c.backoff.recordFailure()
+			remoteClusterReconcileErrors.WithLabelValues(c.name).Inc()
+		} else {
+			c.backoff.recordSuccess()
+		}
+
+		if degradedTransitioned {
+			c.recordDegradedEvent(degraded, err)
+		}
 
-	err := c.reconcileCRDs(remoteConfig, istio)
+		c.reflectStatus(remoteConfig)
+		c.reflectResourceStatus(remoteConfig, resourceStatuses)
+	}()
+
+	c.setRemoteConfig(remoteConfig)
+
+	// c.restConfig, c.cl, c.dynamicClient and the initClient/initInformers
+	// Onces are also read and rewritten by UpdateConfig when credentials
+	// are rotated; take c.mu for the rest of this reconcile so a rotation
+	// landing mid-reconcile can't tear them out from under us or race the
+	// two Once-guarded init calls against each other.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The CRDs have to land and become Established before the remote
+	// cluster's cache is started below: that cache (and the controllers
+	// c.provider.Engage registers against it) watches CRD-backed kinds
+	// like MeshGateway, so starting it against a cluster that doesn't
+	// have the CRD yet races the CRD's own apply. Bootstrap a client
+	// directly off c.restConfig rather than c.dynamicClient/c.cl, neither
+	// of which exist until initK8SClients has run.
+	crdClient, crdMapper, err := c.bootstrapClients()
 	if err != nil {
-		return emperror.Wrapf(err, "could not reconcile")
+		return emperror.Wrap(err, "could not create bootstrap clients for CRDs")
 	}
 
-	c.remoteConfig = remoteConfig
+	crdPipeline := pipeline.New(crdClient, crdMapper, fieldManager)
+	if err = c.reconcileCRDs(remoteConfig, istio, crdPipeline); err != nil {
+		return emperror.Wrap(err, "could not reconcile CRDs")
+	}
+
+	crdStatuses, err := crdPipeline.Apply(context.Background())
+	resourceStatuses = append(resourceStatuses, crdStatuses...)
+	if err != nil {
+		return emperror.Wrap(err, "could not apply remote cluster CRDs")
+	}
 
 	// init k8s clients
 	c.initClient.Do(func() {
@@ -180,26 +300,51 @@ func (c *Cluster) Reconcile(remoteConfig *istiov1beta1.RemoteIstio, istio *istio
 		return emperror.Wrap(err, "could not init k8s informers")
 	}
 
-	ReconcilerFuncs = append(ReconcilerFuncs,
+	p := pipeline.New(c.dynamicClient, c.cl.GetRESTMapper(), fieldManager)
+
+	collectors := []func(remoteConfig *istiov1beta1.RemoteIstio, istio *istiov1beta1.Istio, p *pipeline.Pipeline) error{
 		c.reconcileConfig,
 		c.reconcileSignCert,
 		c.reconcileCARootToNamespaces,
 		c.reconcileEnabledServices,
 		c.ReconcileEnabledServiceEndpoints,
 		c.reconcileComponents,
-	)
+	}
 
-	for _, f := range ReconcilerFuncs {
-		if err := f(remoteConfig, istio); err != nil {
+	for _, collect := range collectors {
+		if err = collect(remoteConfig, istio, p); err != nil {
 			return emperror.Wrapf(err, "could not reconcile")
 		}
 	}
 
+	statuses, err := p.Apply(context.Background())
+	resourceStatuses = append(resourceStatuses, statuses...)
+	if err != nil {
+		return emperror.Wrap(err, "could not apply remote cluster resources")
+	}
+
 	return nil
 }
 
+// bootstrapClients returns a dynamic client and RESTMapper built directly
+// from c.restConfig, for applying the CRD tier before startCluster (and
+// thus c.dynamicClient/c.cl.GetRESTMapper()) has run.
+func (c *Cluster) bootstrapClients() (dynamic.Interface, meta.RESTMapper, error) {
+	dynamicClient, err := dynamic.NewForConfig(c.restConfig)
+	if err != nil {
+		return nil, nil, emperror.Wrap(err, "could not get dynamic client")
+	}
+
+	mapper, err := apiutil.NewDiscoveryRESTMapper(c.restConfig)
+	if err != nil {
+		return nil, nil, emperror.Wrap(err, "could not get rest mapper")
+	}
+
+	return dynamicClient, mapper, nil
+}
+
 func (c *Cluster) GetRemoteConfig() *istiov1beta1.RemoteIstio {
-	return c.remoteConfig
+	return c.getRemoteConfig()
 }
 
 func (c *Cluster) RemoveRemoteIstioComponents() error {
@@ -223,37 +368,126 @@ func (c *Cluster) RemoveRemoteIstioComponents() error {
 
 func (c *Cluster) Shutdown() {
 	c.log.Info("shutdown remote cluster manager")
+
+	if c.cl != nil {
+		if err := c.provider.Disengage(context.Background(), c.name); err != nil {
+			c.log.Error(err, "could not disengage cluster from controllers")
+		}
+	}
+
 	close(c.stopper)
 }
 
-func (c *Cluster) getRestConfig(kubeconfig []byte) (*rest.Config, error) {
-	clusterConfig, err := clientcmd.Load(kubeconfig)
+// HandleSecretUpdate is the intended entry point for the kubeconfig
+// Secret's controller UpdateFunc: given the Secret's new contents and the
+// key the kubeconfig is stored under, it calls UpdateConfig with a
+// KubeconfigProvider built from the rotated bytes.
+func (c *Cluster) HandleSecretUpdate(secret *corev1.Secret, kubeconfigKey string) error {
+	kubeconfig, ok := secret.Data[kubeconfigKey]
+	if !ok {
+		return errors.Errorf("secret %s/%s has no key %q", secret.Namespace, secret.Name, kubeconfigKey)
+	}
+
+	return c.UpdateConfig(KubeconfigProvider{Kubeconfig: kubeconfig})
+}
+
+// UpdateConfig reconciles a change to the credentials that originally
+// seeded this cluster, e.g. after the Secret or file a ConfigProvider reads
+// from was rotated. If the resulting REST config doesn't actually differ
+// (same server, same credentials), it is a no-op. Otherwise the current
+// cluster.Cluster and informers are torn down and rebuilt against the new config,
+// keeping the existing RemoteIstio association intact so callers don't need
+// to delete and recreate the RemoteIstio object to rotate credentials.
+func (c *Cluster) UpdateConfig(configProvider ConfigProvider) error {
+	restConfig, err := configProvider.GetRestConfig()
+	if err != nil {
+		return emperror.Wrap(err, "could not get k8s rest config")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !restConfigChanged(c.restConfig, restConfig) {
+		c.configProvider = configProvider
+		return nil
+	}
+
+	c.log.Info("remote cluster credentials changed, reinitializing remote cluster clients")
+
+	c.Shutdown()
+
+	stop := make(chan struct{})
+	c.stop = stop
+	c.stopper = stop
+
+	c.configProvider = configProvider
+	c.restConfig = restConfig
+	c.initClient = sync.Once{}
+	c.initInformers = sync.Once{}
+
+	if err := c.StartHealthCheck(c.healthCheckInterval); err != nil {
+		return emperror.Wrap(err, "could not restart health check")
+	}
+
+	c.initClient.Do(func() {
+		err = c.initK8SClients()
+	})
 	if err != nil {
-		return nil, emperror.Wrap(err, "could not load kubeconfig")
+		return emperror.Wrap(err, "could not init k8s clients")
 	}
 
-	rest, err := clientcmd.NewDefaultClientConfig(*clusterConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	c.initInformers.Do(func() {
+		err = c.initK8sInformers()
+	})
 	if err != nil {
-		return nil, emperror.Wrap(err, "could not create k8s rest config")
+		return emperror.Wrap(err, "could not init k8s informers")
+	}
+
+	return nil
+}
+
+// restConfigChanged reports whether two REST configs point at a different
+// API server or carry different credentials, ignoring fields (like rate
+// limiter settings) that don't affect what cluster we talk to or as whom.
+// WrapTransport is a function and is not compared, so a credential that
+// lives only inside it (as with TokenFileProvider's token file) is not seen.
+func restConfigChanged(old, new *rest.Config) bool {
+	if old == nil || new == nil {
+		return old != new
 	}
 
-	return rest, nil
+	return old.Host != new.Host ||
+		old.BearerToken != new.BearerToken ||
+		old.BearerTokenFile != new.BearerTokenFile ||
+		old.Username != new.Username ||
+		old.Password != new.Password ||
+		old.CAFile != new.CAFile ||
+		old.CertFile != new.CertFile ||
+		old.KeyFile != new.KeyFile ||
+		!bytes.Equal(old.CAData, new.CAData) ||
+		!bytes.Equal(old.CertData, new.CertData) ||
+		!bytes.Equal(old.KeyData, new.KeyData) ||
+		!reflect.DeepEqual(old.ExecProvider, new.ExecProvider) ||
+		!reflect.DeepEqual(old.AuthProvider, new.AuthProvider)
 }
 
-func (c *Cluster) startManager(config *rest.Config) error {
-	mgr, err := manager.New(config, manager.Options{
-		MetricsBindAddress: "0", // disable metrics
-	})
+// startCluster creates a cluster.Cluster (its own cache, client and scheme)
+// for the remote cluster instead of a full manager.Manager, so engaging N
+// remote clusters no longer costs N managers' worth of goroutines and
+// memory. The reconcilers themselves stay registered once on the root
+// manager and are engaged against this cluster through c.provider.
+func (c *Cluster) startCluster(config *rest.Config) error {
+	cl, err := cluster.New(config)
 	if err != nil {
-		return emperror.Wrap(err, "could not create manager")
+		return emperror.Wrap(err, "could not create cluster")
 	}
 
-	c.mgr = mgr
+	c.cl = cl
 	go func() {
-		c.mgr.Start(c.stop)
+		c.cl.Start(c.stop)
 	}()
 
-	c.mgr.GetCache().WaitForCacheSync(c.stop)
+	c.cl.GetCache().WaitForCacheSync(c.stop)
 
 	return nil
 }
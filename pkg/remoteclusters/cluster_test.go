@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestRestConfigChanged(t *testing.T) {
+	base := &rest.Config{
+		Host:        "https://cluster.example.com",
+		BearerToken: "token",
+		CAData:      []byte("ca"),
+	}
+
+	tests := []struct {
+		name string
+		old  *rest.Config
+		new  *rest.Config
+		want bool
+	}{
+		{
+			name: "identical",
+			old:  base,
+			new:  &rest.Config{Host: base.Host, BearerToken: base.BearerToken, CAData: base.CAData},
+			want: false,
+		},
+		{
+			name: "different host",
+			old:  base,
+			new:  &rest.Config{Host: "https://other.example.com", BearerToken: base.BearerToken, CAData: base.CAData},
+			want: true,
+		},
+		{
+			name: "different bearer token",
+			old:  base,
+			new:  &rest.Config{Host: base.Host, BearerToken: "other-token", CAData: base.CAData},
+			want: true,
+		},
+		{
+			name: "different CA data",
+			old:  base,
+			new:  &rest.Config{Host: base.Host, BearerToken: base.BearerToken, CAData: []byte("other-ca")},
+			want: true,
+		},
+		{
+			name: "nil old",
+			old:  nil,
+			new:  base,
+			want: true,
+		},
+		{
+			name: "both nil",
+			old:  nil,
+			new:  nil,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restConfigChanged(tt.old, tt.new); got != tt.want {
+				t.Errorf("restConfigChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/goph/emperror"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ConfigProvider resolves a *rest.Config for a remote cluster. Having
+// Cluster depend on this interface instead of a raw kubeconfig blob lets
+// the operator be pointed at clusters where distributing a long-lived
+// kubeconfig isn't acceptable, or where credentials expire faster than the
+// operator's reconcile loop runs.
+type ConfigProvider interface {
+	GetRestConfig() (*rest.Config, error)
+}
+
+// KubeconfigProvider resolves a *rest.Config from a raw, clientcmd-loadable
+// kubeconfig blob. This is the original, and still default, way a
+// RemoteIstio is wired up, e.g. from the bytes of a Secret.
+type KubeconfigProvider struct {
+	Kubeconfig []byte
+}
+
+func (p KubeconfigProvider) GetRestConfig() (*rest.Config, error) {
+	clusterConfig, err := clientcmd.Load(p.Kubeconfig)
+	if err != nil {
+		return nil, emperror.Wrap(err, "could not load kubeconfig")
+	}
+
+	restConfig, err := clientcmd.NewDefaultClientConfig(*clusterConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, emperror.Wrap(err, "could not create k8s rest config")
+	}
+
+	return restConfig, nil
+}
+
+// ServiceAccountProvider resolves a *rest.Config for a remote cluster using
+// an in-cluster ServiceAccount token and CA bundle, e.g. one mounted from a
+// Secret referenced by a RemoteIstio's ClusterRef, instead of a standalone
+// kubeconfig. The token file is re-read by client-go on every request, so
+// a rotated ServiceAccount token is picked up without any action on our
+// part.
+type ServiceAccountProvider struct {
+	Host      string
+	CAData    []byte
+	TokenFile string
+}
+
+func (p ServiceAccountProvider) GetRestConfig() (*rest.Config, error) {
+	return &rest.Config{
+		Host:            p.Host,
+		TLSClientConfig: rest.TLSClientConfig{CAData: p.CAData},
+		BearerTokenFile: p.TokenFile,
+	}, nil
+}
+
+// ExecProvider resolves a *rest.Config whose credentials are produced on
+// demand by an external exec plugin, e.g. aws-iam-authenticator, gcloud or
+// az, so short-lived cloud-provider tokens never have to be baked into a
+// static kubeconfig.
+type ExecProvider struct {
+	Host   string
+	CAData []byte
+	Exec   *clientcmdapi.ExecConfig
+}
+
+func (p ExecProvider) GetRestConfig() (*rest.Config, error) {
+	return &rest.Config{
+		Host:            p.Host,
+		TLSClientConfig: rest.TLSClientConfig{CAData: p.CAData},
+		ExecProvider:    p.Exec,
+	}, nil
+}
+
+// TokenFileProvider resolves a *rest.Config whose bearer token is re-read
+// from TokenFile on every request, for OIDC or other credentials that are
+// rotated on disk by an external process.
+type TokenFileProvider struct {
+	Host      string
+	CAData    []byte
+	TokenFile string
+}
+
+func (p TokenFileProvider) GetRestConfig() (*rest.Config, error) {
+	restConfig := &rest.Config{
+		Host:            p.Host,
+		TLSClientConfig: rest.TLSClientConfig{CAData: p.CAData},
+	}
+
+	tokenFile := p.TokenFile
+	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &tokenFileRoundTripper{tokenFile: tokenFile, rt: rt}
+	}
+
+	return restConfig, nil
+}
+
+// Credentials is the union a RemoteIstio's spec carries to select how its
+// ConfigProvider is built; exactly one field should be set. It mirrors the
+// ConfigProvider implementations in this file one-to-one so NewConfigProvider
+// is a straight conversion.
+type Credentials struct {
+	Kubeconfig     []byte
+	ServiceAccount *ServiceAccountCredentials
+	Exec           *ExecCredentials
+	TokenFile      *TokenFileCredentials
+}
+
+// ServiceAccountCredentials is the Credentials variant resolved into a
+// ServiceAccountProvider.
+type ServiceAccountCredentials struct {
+	Host      string
+	CAData    []byte
+	TokenFile string
+}
+
+// ExecCredentials is the Credentials variant resolved into an ExecProvider.
+type ExecCredentials struct {
+	Host   string
+	CAData []byte
+	Exec   *clientcmdapi.ExecConfig
+}
+
+// TokenFileCredentials is the Credentials variant resolved into a
+// TokenFileProvider.
+type TokenFileCredentials struct {
+	Host      string
+	CAData    []byte
+	TokenFile string
+}
+
+// NewConfigProvider builds the ConfigProvider selected by creds. Exactly
+// one of creds' fields must be set; it is an error to set none or more
+// than one.
+func NewConfigProvider(creds Credentials) (ConfigProvider, error) {
+	var provider ConfigProvider
+	set := 0
+
+	if creds.Kubeconfig != nil {
+		provider = KubeconfigProvider{Kubeconfig: creds.Kubeconfig}
+		set++
+	}
+	if creds.ServiceAccount != nil {
+		provider = ServiceAccountProvider{
+			Host:      creds.ServiceAccount.Host,
+			CAData:    creds.ServiceAccount.CAData,
+			TokenFile: creds.ServiceAccount.TokenFile,
+		}
+		set++
+	}
+	if creds.Exec != nil {
+		provider = ExecProvider{
+			Host:   creds.Exec.Host,
+			CAData: creds.Exec.CAData,
+			Exec:   creds.Exec.Exec,
+		}
+		set++
+	}
+	if creds.TokenFile != nil {
+		provider = TokenFileProvider{
+			Host:      creds.TokenFile.Host,
+			CAData:    creds.TokenFile.CAData,
+			TokenFile: creds.TokenFile.TokenFile,
+		}
+		set++
+	}
+
+	switch set {
+	case 0:
+		return nil, errors.New("credentials: exactly one of Kubeconfig, ServiceAccount, Exec or TokenFile must be set")
+	case 1:
+		return provider, nil
+	default:
+		return nil, errors.New("credentials: only one of Kubeconfig, ServiceAccount, Exec or TokenFile may be set")
+	}
+}
+
+type tokenFileRoundTripper struct {
+	tokenFile string
+	rt        http.RoundTripper
+}
+
+func (t *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := ioutil.ReadFile(t.tokenFile)
+	if err != nil {
+		return nil, emperror.Wrap(err, "could not read token file")
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	istiov1beta1 "github.com/banzaicloud/istio-operator/pkg/apis/istio/v1beta1"
+	"github.com/banzaicloud/istio-operator/pkg/remoteclusters/pipeline"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	// healthCheckTimeout bounds each health check's ServerVersion call, so a
+	// remote cluster that's gone dark on the network (as opposed to
+	// actively refusing connections) can't hang the single health-check
+	// goroutine forever and wedge the very detector meant to catch it.
+	healthCheckTimeout  = 10 * time.Second
+	maxReconcileBackoff = 5 * time.Minute
+)
+
+// ClusterStatus is the point-in-time connectivity and reconcile health of a
+// remote cluster, as seen from this operator instance.
+type ClusterStatus struct {
+	Reachable          bool
+	LastTransitionTime metav1.Time
+
+	// Degraded reports whether the cluster's most recent Reconcile failed.
+	// Unlike Reachable, which tracks API server connectivity from the
+	// background health check, Degraded reflects the reconcile loop's own
+	// outcome, so a cluster can be Reachable but Degraded (e.g. a
+	// reconciled resource failed to apply or never became ready).
+	Degraded           bool
+	LastReconcileTime  metav1.Time
+	LastReconcileError string
+}
+
+// backoffState gates repeated reconcile attempts against a remote cluster
+// that is failing, backing off exponentially instead of hammering an
+// unreachable API server.
+type backoffState struct {
+	mu       sync.Mutex
+	failures int
+	next     time.Time
+}
+
+func (b *backoffState) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.next.IsZero() || time.Now().After(b.next)
+}
+
+func (b *backoffState) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.next = time.Time{}
+}
+
+func (b *backoffState) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	delay := time.Duration(math.Pow(2, float64(b.failures))) * time.Second
+	if delay > maxReconcileBackoff {
+		delay = maxReconcileBackoff
+	}
+	b.next = time.Now().Add(delay)
+}
+
+// GetStatus returns the cluster's current connectivity and reconcile
+// health, e.g. for reflecting into RemoteIstio.Status.Clusters[name].
+func (c *Cluster) GetStatus() ClusterStatus {
+	c.statusMu.RLock()
+	defer c.statusMu.RUnlock()
+
+	return c.status
+}
+
+// reflectStatus writes this cluster's current status onto
+// remoteConfig.Status.Clusters[name], so that it gets persisted the next
+// time the caller updates the RemoteIstio's status subresource.
+func (c *Cluster) reflectStatus(remoteConfig *istiov1beta1.RemoteIstio) {
+	if remoteConfig == nil {
+		return
+	}
+
+	status := c.GetStatus()
+
+	if remoteConfig.Status.Clusters == nil {
+		remoteConfig.Status.Clusters = make(map[string]istiov1beta1.RemoteClusterStatus)
+	}
+
+	remoteConfig.Status.Clusters[c.name] = istiov1beta1.RemoteClusterStatus{
+		Reachable:          status.Reachable,
+		LastTransitionTime: status.LastTransitionTime,
+		Degraded:           status.Degraded,
+		LastReconcileTime:  status.LastReconcileTime,
+		LastReconcileError: status.LastReconcileError,
+	}
+}
+
+// reflectResourceStatus writes the outcome of applying this reconcile's
+// pipeline objects onto remoteConfig.Status.Resources, so that users can
+// see which resources landed on the remote cluster and which are still
+// waiting to become ready, rather than only the cluster-level summary
+// reflectStatus provides.
+func (c *Cluster) reflectResourceStatus(remoteConfig *istiov1beta1.RemoteIstio, statuses []pipeline.ObjectStatus) {
+	if remoteConfig == nil {
+		return
+	}
+
+	resources := make([]istiov1beta1.RemoteResourceStatus, len(statuses))
+	for i, status := range statuses {
+		resources[i] = istiov1beta1.RemoteResourceStatus{
+			Kind:      status.Kind,
+			Namespace: status.Namespace,
+			Name:      status.Name,
+			Applied:   status.Applied,
+			Ready:     status.Ready,
+			Error:     status.Error,
+		}
+	}
+
+	remoteConfig.Status.Resources = resources
+}
+
+// StartHealthCheck periodically pings the remote cluster's API server and
+// keeps GetStatus up to date, emitting a Kubernetes Event and flipping the
+// remote_cluster_up metric whenever reachability changes. It returns
+// immediately; the checks run in a background goroutine until the
+// cluster's stop channel is closed.
+func (c *Cluster) StartHealthCheck(interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	c.healthCheckInterval = interval
+
+	healthCheckConfig := rest.CopyConfig(c.restConfig)
+	healthCheckConfig.Timeout = healthCheckTimeout
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(healthCheckConfig)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.checkHealth(discoveryClient)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Cluster) checkHealth(discoveryClient discovery.DiscoveryInterface) {
+	_, err := discoveryClient.ServerVersion()
+	reachable := err == nil
+
+	c.statusMu.Lock()
+	// The first check is always a transition, out of the not-yet-checked
+	// state, so LastTransitionTime and an Event record the initial result.
+	firstCheck := c.status.LastTransitionTime.IsZero()
+	wasReachable := c.status.Reachable
+	transitioned := firstCheck || wasReachable != reachable
+	c.status.Reachable = reachable
+	if transitioned {
+		c.status.LastTransitionTime = metav1.Now()
+	}
+	c.statusMu.Unlock()
+
+	if reachable {
+		remoteClusterUp.WithLabelValues(c.name).Set(1)
+	} else {
+		remoteClusterUp.WithLabelValues(c.name).Set(0)
+	}
+
+	if !transitioned {
+		return
+	}
+
+	if reachable {
+		c.log.Info("remote cluster became reachable")
+	} else {
+		c.log.Error(err, "remote cluster became unreachable")
+	}
+
+	c.recordEvent(reachable, err)
+}
+
+func (c *Cluster) recordEvent(reachable bool, cause error) {
+	remoteConfig := c.getRemoteConfig()
+	if c.recorder == nil || remoteConfig == nil {
+		return
+	}
+
+	eventType := corev1.EventTypeWarning
+	reason := "RemoteClusterUnreachable"
+	message := "remote cluster API server is unreachable"
+	if cause != nil {
+		message = "remote cluster API server is unreachable: " + cause.Error()
+	}
+	if reachable {
+		eventType = corev1.EventTypeNormal
+		reason = "RemoteClusterReachable"
+		message = "remote cluster API server is reachable again"
+	}
+
+	c.recorder.Event(remoteConfig, eventType, reason, message)
+}
+
+// recordDegradedEvent emits a Kubernetes Event when a cluster's reconcile
+// health transitions between Degraded and Ready, mirroring recordEvent's
+// Reachable/Unreachable transitions but for the reconcile loop's own
+// outcome rather than API server connectivity.
+func (c *Cluster) recordDegradedEvent(degraded bool, cause error) {
+	remoteConfig := c.getRemoteConfig()
+	if c.recorder == nil || remoteConfig == nil {
+		return
+	}
+
+	eventType := corev1.EventTypeWarning
+	reason := "RemoteClusterDegraded"
+	message := "remote cluster reconcile is failing"
+	if cause != nil {
+		message = "remote cluster reconcile is failing: " + cause.Error()
+	}
+	if !degraded {
+		eventType = corev1.EventTypeNormal
+		reason = "RemoteClusterReady"
+		message = "remote cluster reconcile recovered"
+	}
+
+	c.recorder.Event(remoteConfig, eventType, reason, message)
+}
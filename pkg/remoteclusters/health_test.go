@@ -0,0 +1,275 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	istiov1beta1 "github.com/banzaicloud/istio-operator/pkg/apis/istio/v1beta1"
+	"github.com/banzaicloud/istio-operator/pkg/remoteclusters/pipeline"
+)
+
+// testLogger is a minimal no-op logr.Logger for tests, so Cluster methods
+// that log can be exercised without pulling in a concrete logr backend.
+type testLogger struct{}
+
+func (testLogger) Enabled() bool                                             { return false }
+func (testLogger) Info(msg string, keysAndValues ...interface{})             {}
+func (testLogger) Error(err error, msg string, keysAndValues ...interface{}) {}
+func (l testLogger) V(level int) logr.Logger                                 { return l }
+func (l testLogger) WithValues(keysAndValues ...interface{}) logr.Logger     { return l }
+func (l testLogger) WithName(name string) logr.Logger                        { return l }
+
+// fakeDiscoveryClient is a discovery.DiscoveryInterface stub that only
+// implements ServerVersion, the only method checkHealth calls; every other
+// method is inherited (and left panicking) from the embedded nil interface.
+type fakeDiscoveryClient struct {
+	discovery.DiscoveryInterface
+	version *version.Info
+	err     error
+}
+
+func (f *fakeDiscoveryClient) ServerVersion() (*version.Info, error) {
+	return f.version, f.err
+}
+
+func TestBackoffStateReadyByDefault(t *testing.T) {
+	var b backoffState
+
+	if !b.ready() {
+		t.Error("zero-value backoffState should be ready")
+	}
+}
+
+func TestBackoffStateRecordFailureBacksOffExponentially(t *testing.T) {
+	var b backoffState
+
+	for failures := 1; failures <= 4; failures++ {
+		before := time.Now()
+		b.recordFailure()
+
+		want := time.Duration(math.Pow(2, float64(failures))) * time.Second
+		if want > maxReconcileBackoff {
+			want = maxReconcileBackoff
+		}
+
+		got := b.next.Sub(before)
+		if got < want-time.Second || got > want+time.Second {
+			t.Errorf("after %d failures, next-before = %v, want ~%v", failures, got, want)
+		}
+
+		if b.ready() {
+			t.Errorf("after %d failures, backoffState should not be ready yet", failures)
+		}
+	}
+}
+
+func TestBackoffStateRecordFailureCapsAtMaxReconcileBackoff(t *testing.T) {
+	var b backoffState
+
+	for i := 0; i < 10; i++ {
+		b.recordFailure()
+	}
+
+	if delay := time.Until(b.next); delay > maxReconcileBackoff {
+		t.Errorf("backoff delay = %v, want capped at %v", delay, maxReconcileBackoff)
+	}
+}
+
+func TestBackoffStateRecordSuccessResets(t *testing.T) {
+	var b backoffState
+
+	b.recordFailure()
+	b.recordFailure()
+
+	b.recordSuccess()
+
+	if b.failures != 0 {
+		t.Errorf("failures = %d, want 0", b.failures)
+	}
+	if !b.ready() {
+		t.Error("backoffState should be ready again after recordSuccess")
+	}
+}
+
+func newTestRemoteIstio() *istiov1beta1.RemoteIstio {
+	return &istiov1beta1.RemoteIstio{
+		ObjectMeta: metav1.ObjectMeta{Name: "remote", Namespace: "istio-system"},
+	}
+}
+
+func TestCheckHealthTransitionsReachability(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &Cluster{name: "test-cluster", log: testLogger{}, recorder: recorder}
+	c.setRemoteConfig(newTestRemoteIstio())
+
+	c.checkHealth(&fakeDiscoveryClient{err: errors.New("connection refused")})
+
+	status := c.GetStatus()
+	if status.Reachable {
+		t.Fatal("expected Reachable=false after a failing health check")
+	}
+	if status.LastTransitionTime.IsZero() {
+		t.Error("expected LastTransitionTime to be set on the first transition")
+	}
+	select {
+	case event := <-recorder.Events:
+		if event == "" {
+			t.Error("expected a non-empty Event on the Unreachable transition")
+		}
+	default:
+		t.Error("expected an Event to be recorded on the Unreachable transition")
+	}
+
+	c.checkHealth(&fakeDiscoveryClient{version: &version.Info{}})
+
+	status = c.GetStatus()
+	if !status.Reachable {
+		t.Fatal("expected Reachable=true after a succeeding health check")
+	}
+	transitionTime := status.LastTransitionTime
+
+	// A repeated success shouldn't re-trigger a transition (or a new Event).
+	c.checkHealth(&fakeDiscoveryClient{version: &version.Info{}})
+	if c.GetStatus().LastTransitionTime != transitionTime {
+		t.Error("LastTransitionTime changed without a reachability transition")
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected Event on a repeated success: %q", event)
+	default:
+	}
+}
+
+func TestRecordDegradedEventReasons(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &Cluster{name: "test-cluster", recorder: recorder}
+	c.setRemoteConfig(newTestRemoteIstio())
+
+	c.recordDegradedEvent(true, errors.New("boom"))
+	select {
+	case event := <-recorder.Events:
+		if !containsAll(event, "Warning", "RemoteClusterDegraded", "boom") {
+			t.Errorf("degraded event = %q, want it to mention Warning/RemoteClusterDegraded/boom", event)
+		}
+	default:
+		t.Fatal("expected an Event on transition to Degraded")
+	}
+
+	c.recordDegradedEvent(false, nil)
+	select {
+	case event := <-recorder.Events:
+		if !containsAll(event, "Normal", "RemoteClusterReady") {
+			t.Errorf("ready event = %q, want it to mention Normal/RemoteClusterReady", event)
+		}
+	default:
+		t.Fatal("expected an Event on transition to Ready")
+	}
+}
+
+func containsAll(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReflectStatus(t *testing.T) {
+	c := &Cluster{name: "test-cluster"}
+	c.status = ClusterStatus{Reachable: true, Degraded: true, LastReconcileError: "boom"}
+
+	remoteConfig := newTestRemoteIstio()
+	c.reflectStatus(remoteConfig)
+
+	got, ok := remoteConfig.Status.Clusters["test-cluster"]
+	if !ok {
+		t.Fatal("expected Status.Clusters to contain an entry for test-cluster")
+	}
+	if !got.Reachable || !got.Degraded || got.LastReconcileError != "boom" {
+		t.Errorf("got %+v, want Reachable=true Degraded=true LastReconcileError=boom", got)
+	}
+}
+
+func TestReflectStatusNilRemoteConfigIsNoop(t *testing.T) {
+	c := &Cluster{name: "test-cluster"}
+	c.reflectStatus(nil)
+}
+
+func TestReflectResourceStatus(t *testing.T) {
+	c := &Cluster{}
+	remoteConfig := newTestRemoteIstio()
+
+	c.reflectResourceStatus(remoteConfig, []pipeline.ObjectStatus{
+		{Kind: "Namespace", Name: "istio-system", Applied: true, Ready: true},
+		{Kind: "Deployment", Name: "istiod", Applied: true, Ready: false, Error: "not ready"},
+	})
+
+	if len(remoteConfig.Status.Resources) != 2 {
+		t.Fatalf("got %d resources, want 2", len(remoteConfig.Status.Resources))
+	}
+	if remoteConfig.Status.Resources[1].Error != "not ready" {
+		t.Errorf("got error %q, want %q", remoteConfig.Status.Resources[1].Error, "not ready")
+	}
+}
+
+func TestStartHealthCheckProbesUntilStopped(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not reserve a port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	stop := make(chan struct{})
+	c := &Cluster{
+		name:       "test-cluster",
+		log:        testLogger{},
+		stop:       stop,
+		restConfig: &rest.Config{Host: "http://" + addr},
+	}
+
+	if err := c.StartHealthCheck(20 * time.Millisecond); err != nil {
+		t.Fatalf("StartHealthCheck() error = %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.GetStatus().Reachable == false && !c.GetStatus().LastTransitionTime.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+
+	if c.GetStatus().Reachable {
+		t.Error("expected Reachable=false against a closed port nobody is listening on")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/goph/emperror"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	istiov1beta1 "github.com/banzaicloud/istio-operator/pkg/apis/istio/v1beta1"
+)
+
+// MeshGatewayProvider is the default Provider: it engages the mesh gateway
+// controller, registered once on the root manager, against every remote
+// cluster as it's added. It replaces the old meshgateway.Add(c.mgr) call
+// that used to run once per per-cluster manager.
+type MeshGatewayProvider struct {
+	ctrl controller.Controller
+	log  logr.Logger
+
+	mu       sync.Mutex
+	cancelFn map[string]context.CancelFunc
+}
+
+// NewMeshGatewayProvider returns a Provider that watches MeshGateway
+// objects on every engaged remote cluster and enqueues the owning
+// RemoteIstio for ctrl to reconcile.
+func NewMeshGatewayProvider(ctrl controller.Controller, log logr.Logger) *MeshGatewayProvider {
+	return &MeshGatewayProvider{
+		ctrl:     ctrl,
+		log:      log,
+		cancelFn: make(map[string]context.CancelFunc),
+	}
+}
+
+func (p *MeshGatewayProvider) Engage(ctx context.Context, name string, remoteIstio types.NamespacedName, cl cluster.Cluster) error {
+	informer, err := cl.GetCache().GetInformerForKind(istiov1beta1.SchemeGroupVersion.WithKind("MeshGateway"))
+	if err != nil {
+		return emperror.Wrap(err, "could not get informer for mesh gateways")
+	}
+
+	ctx, cancel := context.WithCancel(NewContextWithClusterName(ctx, name))
+
+	p.mu.Lock()
+	p.cancelFn[name] = cancel
+	p.mu.Unlock()
+
+	enqueue := func(q workqueue.RateLimitingInterface) {
+		if ctx.Err() != nil {
+			return
+		}
+		p.log.V(1).Info("enqueueing mesh gateway change", "cluster", name, "remoteistio", remoteIstio)
+		enqueueClusterAwareRequest(q, name, remoteIstio)
+	}
+
+	return p.ctrl.Watch(&source.Informer{Informer: informer}, &handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q)
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q)
+		},
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q)
+		},
+		GenericFunc: func(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q)
+		},
+	})
+}
+
+// enqueueClusterAwareRequest adds a ClusterAwareRequest for remoteIstio,
+// scoped to the named cluster, to q. Enqueueing the full ClusterAwareRequest
+// (rather than just its embedded reconcile.Request) is what lets
+// ClusterNameFromRequest recover which cluster a dequeued item came from.
+func enqueueClusterAwareRequest(q workqueue.RateLimitingInterface, name string, remoteIstio types.NamespacedName) {
+	q.Add(ClusterAwareRequest{
+		Request:     reconcile.Request{NamespacedName: remoteIstio},
+		ClusterName: name,
+	})
+}
+
+// Disengage stops dispatching mesh gateway events for the named cluster.
+// The informer itself is torn down along with the rest of the cluster's
+// cache when Cluster.Shutdown closes its stop channel.
+func (p *MeshGatewayProvider) Disengage(ctx context.Context, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cancel, ok := p.cancelFn[name]; ok {
+		cancel()
+		delete(p.cancelFn, name)
+	}
+
+	return nil
+}
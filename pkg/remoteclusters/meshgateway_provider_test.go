@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEnqueueClusterAwareRequestSurvivesDequeue(t *testing.T) {
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	remoteIstio := types.NamespacedName{Namespace: "istio-system", Name: "remote"}
+	enqueueClusterAwareRequest(q, "cluster-a", remoteIstio)
+
+	item, shutdown := q.Get()
+	if shutdown {
+		t.Fatal("queue shut down unexpectedly")
+	}
+	defer q.Done(item)
+
+	name, ok := ClusterNameFromRequest(item)
+	if !ok {
+		t.Fatalf("item %#v is not a ClusterAwareRequest", item)
+	}
+	if name != "cluster-a" {
+		t.Errorf("ClusterName = %q, want %q", name, "cluster-a")
+	}
+
+	req, ok := item.(ClusterAwareRequest)
+	if !ok {
+		t.Fatalf("item %#v is not a ClusterAwareRequest", item)
+	}
+	if req.NamespacedName != remoteIstio {
+		t.Errorf("NamespacedName = %v, want %v", req.NamespacedName, remoteIstio)
+	}
+}
+
+func TestClusterNameFromRequestRejectsBareRequest(t *testing.T) {
+	if _, ok := ClusterNameFromRequest(reconcile.Request{}); ok {
+		t.Error("ClusterNameFromRequest should reject a bare reconcile.Request")
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	remoteClusterUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "remote_cluster_up",
+		Help: "Whether the remote cluster's API server was reachable (1) or not (0) at the last health check.",
+	}, []string{"cluster"})
+
+	remoteClusterReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "remote_cluster_reconcile_duration_seconds",
+		Help: "Time it took to reconcile a remote cluster.",
+	}, []string{"cluster"})
+
+	remoteClusterReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remote_cluster_reconcile_errors_total",
+		Help: "Number of remote cluster reconcile errors.",
+	}, []string{"cluster"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(remoteClusterUp, remoteClusterReconcileDuration, remoteClusterReconcileErrors)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pipeline collects the desired state produced by the remote
+// cluster sub-reconcilers and applies (or tears down) it in a fixed,
+// dependency-aware order, instead of letting each sub-reconciler apply its
+// own objects in whatever order it happens to run. This avoids races such
+// as a MeshGateway being created before its CRD is established.
+package pipeline
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goph/emperror"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// tier is a group of kinds that may be applied in any order relative to one
+// another, but only once every kind in every earlier tier is established.
+type tier int
+
+const (
+	tierNamespace tier = iota
+	tierCRD
+	tierRBAC
+	tierConfig
+	tierService
+	tierWorkload
+	tierNetworking
+	tierUnknown
+)
+
+// installOrder maps a Kind to the tier it gets applied in. Kinds that
+// aren't listed are applied last, in tierUnknown.
+var installOrder = map[string]tier{
+	"Namespace": tierNamespace,
+
+	"CustomResourceDefinition": tierCRD,
+
+	"ServiceAccount":     tierRBAC,
+	"Role":               tierRBAC,
+	"RoleBinding":        tierRBAC,
+	"ClusterRole":        tierRBAC,
+	"ClusterRoleBinding": tierRBAC,
+
+	"ConfigMap": tierConfig,
+	"Secret":    tierConfig,
+
+	"Service": tierService,
+
+	"Deployment":  tierWorkload,
+	"StatefulSet": tierWorkload,
+	"DaemonSet":   tierWorkload,
+
+	"Ingress":        tierNetworking,
+	"Gateway":        tierNetworking,
+	"VirtualService": tierNetworking,
+}
+
+func tierFor(kind string) tier {
+	if t, ok := installOrder[kind]; ok {
+		return t
+	}
+	return tierUnknown
+}
+
+// ReadinessFunc reports whether an applied object has become ready, e.g. a
+// CRD has been established or a Deployment has the requested replicas
+// available. The zero value (nil) is treated as "always ready".
+type ReadinessFunc func(ctx context.Context, client dynamic.ResourceInterface, obj *unstructured.Unstructured) (bool, error)
+
+// Pipeline collects desired objects from the remote cluster sub-reconcilers
+// and applies, or deletes, them in a fixed install order.
+type Pipeline struct {
+	dynamicClient dynamic.Interface
+	mapper        meta.RESTMapper
+	fieldManager  string
+	isReady       ReadinessFunc
+
+	objects []*unstructured.Unstructured
+}
+
+// New returns an empty Pipeline that applies objects with dynamicClient
+// using mapper to resolve GroupVersionResource for each object's GVK.
+// fieldManager identifies this pipeline's ownership for server-side apply.
+func New(dynamicClient dynamic.Interface, mapper meta.RESTMapper, fieldManager string) *Pipeline {
+	return &Pipeline{
+		dynamicClient: dynamicClient,
+		mapper:        mapper,
+		fieldManager:  fieldManager,
+		isReady:       defaultIsReady,
+	}
+}
+
+// WithReadinessFunc overrides how the pipeline decides an applied object is
+// ready before moving on to the next tier.
+func (p *Pipeline) WithReadinessFunc(f ReadinessFunc) *Pipeline {
+	p.isReady = f
+	return p
+}
+
+// Add collects an object to be applied or deleted by Apply/Delete. Objects
+// are sorted into their install tier lazily, at Apply/Delete time, so
+// callers can add objects from every sub-reconciler in any order.
+func (p *Pipeline) Add(objs ...*unstructured.Unstructured) {
+	p.objects = append(p.objects, objs...)
+}
+
+// ObjectStatus is the outcome of applying a single object, meant to be
+// surfaced on the owning RemoteIstio's status so users can see which
+// resources landed and which are still waiting to become ready.
+type ObjectStatus struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Applied   bool
+	Ready     bool
+	Error     string
+}
+
+// Apply applies every collected object in install order (Namespace -> CRD
+// -> ServiceAccount/Role/RoleBinding -> ConfigMap/Secret -> Service ->
+// Deployment/StatefulSet/DaemonSet -> Ingress/Gateway/VirtualService),
+// checking that every object in a tier is ready before moving on to the
+// next one. Readiness is checked once, without polling: if an object is not
+// ready yet, Apply stops there and returns an error. It returns the
+// per-object outcome of every object it got to, even if it stops early
+// because a tier failed to apply or become ready.
+func (p *Pipeline) Apply(ctx context.Context) ([]ObjectStatus, error) {
+	var statuses []ObjectStatus
+
+	for _, t := range p.tiers() {
+		first := len(statuses)
+
+		for _, obj := range t.objects {
+			status := ObjectStatus{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+			client, err := p.resourceFor(obj)
+			if err != nil {
+				status.Error = err.Error()
+				statuses = append(statuses, status)
+				return statuses, emperror.Wrapf(err, "could not get client for %s %s", obj.GetKind(), obj.GetName())
+			}
+
+			if _, err := client.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: p.fieldManager, Force: true}); err != nil {
+				status.Error = err.Error()
+				statuses = append(statuses, status)
+				return statuses, emperror.Wrapf(err, "could not apply %s %s", obj.GetKind(), obj.GetName())
+			}
+			status.Applied = true
+
+			statuses = append(statuses, status)
+		}
+
+		for i, obj := range t.objects {
+			status := &statuses[first+i]
+
+			client, err := p.resourceFor(obj)
+			if err != nil {
+				status.Error = err.Error()
+				return statuses, emperror.Wrapf(err, "could not get client for %s %s", obj.GetKind(), obj.GetName())
+			}
+
+			ready := true
+			if p.isReady != nil {
+				ready, err = p.isReady(ctx, client, obj)
+				if err != nil {
+					status.Error = err.Error()
+					return statuses, emperror.Wrapf(err, "could not check readiness of %s %s", obj.GetKind(), obj.GetName())
+				}
+			}
+			status.Ready = ready
+			if !ready {
+				return statuses, emperror.Wrapf(errNotReady, "%s %s is not ready yet", obj.GetKind(), obj.GetName())
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+var errNotReady = k8serrors.NewServiceUnavailable("resource is not ready yet")
+
+// Delete removes every collected object in the reverse of the install
+// order, so e.g. workloads are torn down before the CRDs they depend on.
+func (p *Pipeline) Delete(ctx context.Context) error {
+	tiers := p.tiers()
+	for i := len(tiers) - 1; i >= 0; i-- {
+		for _, obj := range tiers[i].objects {
+			client, err := p.resourceFor(obj)
+			if err != nil {
+				return emperror.Wrapf(err, "could not get client for %s %s", obj.GetKind(), obj.GetName())
+			}
+
+			err = client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return emperror.Wrapf(err, "could not delete %s %s", obj.GetKind(), obj.GetName())
+			}
+		}
+	}
+
+	return nil
+}
+
+type tierObjects struct {
+	tier    tier
+	objects []*unstructured.Unstructured
+}
+
+func (p *Pipeline) tiers() []tierObjects {
+	byTier := make(map[tier][]*unstructured.Unstructured)
+	for _, obj := range p.objects {
+		t := tierFor(obj.GetKind())
+		byTier[t] = append(byTier[t], obj)
+	}
+
+	tiers := make([]tierObjects, 0, len(byTier))
+	for t, objs := range byTier {
+		tiers = append(tiers, tierObjects{tier: t, objects: objs})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].tier < tiers[j].tier
+	})
+
+	return tiers
+}
+
+func (p *Pipeline) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+
+	mapping, err := p.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	gvr := schema.GroupVersionResource{Group: mapping.Resource.Group, Version: mapping.Resource.Version, Resource: mapping.Resource.Resource}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() != "" {
+		return p.dynamicClient.Resource(gvr).Namespace(obj.GetNamespace()), nil
+	}
+
+	return p.dynamicClient.Resource(gvr), nil
+}
+
+func defaultIsReady(ctx context.Context, client dynamic.ResourceInterface, obj *unstructured.Unstructured) (bool, error) {
+	switch obj.GetKind() {
+	case "CustomResourceDefinition":
+		current, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return crdEstablished(current), nil
+	default:
+		return true, nil
+	}
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, found, err := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	if err != nil || !found {
+		return false
+	}
+
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipeline
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newUnstructured(kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+func TestTierFor(t *testing.T) {
+	tests := []struct {
+		kind string
+		want tier
+	}{
+		{"Namespace", tierNamespace},
+		{"CustomResourceDefinition", tierCRD},
+		{"ServiceAccount", tierRBAC},
+		{"ClusterRoleBinding", tierRBAC},
+		{"ConfigMap", tierConfig},
+		{"Secret", tierConfig},
+		{"Service", tierService},
+		{"Deployment", tierWorkload},
+		{"StatefulSet", tierWorkload},
+		{"VirtualService", tierNetworking},
+		{"SomeUnlistedKind", tierUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if got := tierFor(tt.kind); got != tt.want {
+				t.Errorf("tierFor(%q) = %v, want %v", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineTiersOrdering(t *testing.T) {
+	p := &Pipeline{}
+	p.Add(
+		newUnstructured("Deployment", "web"),
+		newUnstructured("VirtualService", "web"),
+		newUnstructured("Namespace", "istio-system"),
+		newUnstructured("CustomResourceDefinition", "virtualservices.networking.istio.io"),
+		newUnstructured("ConfigMap", "istio"),
+		newUnstructured("Secret", "istio-ca"),
+	)
+
+	tiers := p.tiers()
+
+	var got []tier
+	for _, t := range tiers {
+		got = append(got, t.tier)
+	}
+
+	want := []tier{tierNamespace, tierCRD, tierConfig, tierWorkload, tierNetworking}
+	if len(got) != len(want) {
+		t.Fatalf("tiers() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("tiers()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// ConfigMap and Secret both belong to tierConfig and must be grouped
+	// together rather than split across two tiers.
+	for _, tr := range tiers {
+		if tr.tier == tierConfig && len(tr.objects) != 2 {
+			t.Errorf("tierConfig has %d objects, want 2 (ConfigMap and Secret)", len(tr.objects))
+		}
+	}
+}
+
+func TestCRDEstablished(t *testing.T) {
+	tests := []struct {
+		name string
+		crd  *unstructured.Unstructured
+		want bool
+	}{
+		{
+			name: "established",
+			crd: withConditions(map[string]interface{}{
+				"type":   "Established",
+				"status": "True",
+			}),
+			want: true,
+		},
+		{
+			name: "established condition false",
+			crd: withConditions(map[string]interface{}{
+				"type":   "Established",
+				"status": "False",
+			}),
+			want: false,
+		},
+		{
+			name: "no conditions",
+			crd:  newUnstructured("CustomResourceDefinition", "foos.example.com"),
+			want: false,
+		},
+		{
+			name: "unrelated condition only",
+			crd: withConditions(map[string]interface{}{
+				"type":   "NamesAccepted",
+				"status": "True",
+			}),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crdEstablished(tt.crd); got != tt.want {
+				t.Errorf("crdEstablished() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func withConditions(condition map[string]interface{}) *unstructured.Unstructured {
+	crd := newUnstructured("CustomResourceDefinition", "foos.example.com")
+	if err := unstructured.SetNestedSlice(crd.Object, []interface{}{condition}, "status", "conditions"); err != nil {
+		panic(err)
+	}
+	return crd
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Banzai Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteclusters
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Provider is handed every remote cluster.Cluster as it becomes available or
+// goes away, so controllers registered once against the root manager can be
+// engaged against each remote cluster instead of every Cluster spinning up
+// its own copy of them. This mirrors the cluster-provider shape being
+// proposed for controller-runtime's multicluster support.
+type Provider interface {
+	// Engage is called when a remote cluster starts being reconciled.
+	// remoteIstio is the NamespacedName of the RemoteIstio CR that owns the
+	// cluster; once a Provider has registered the cluster's cache with the
+	// controllers that watch it, changes seen there enqueue requests for
+	// remoteIstio (MeshGatewayProvider enqueues a ClusterAwareRequest on
+	// every MeshGateway event).
+	Engage(ctx context.Context, name string, remoteIstio types.NamespacedName, cl cluster.Cluster) error
+	// Disengage is called when a remote cluster is removed or shut down. It
+	// should undo whatever Engage registered.
+	Disengage(ctx context.Context, name string) error
+}
+
+// ClusterAwareRequest carries the name of the remote cluster a
+// reconcile.Request belongs to. Controllers that are engaged against more
+// than one remote cluster use this to know which cluster's client/cache to
+// dispatch the request against.
+type ClusterAwareRequest struct {
+	reconcile.Request
+	ClusterName string
+}
+
+type clusterNameContextKey struct{}
+
+// NewContextWithClusterName returns a copy of ctx carrying the name of the
+// remote cluster the current reconcile is running against.
+func NewContextWithClusterName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, clusterNameContextKey{}, name)
+}
+
+// ClusterNameFromContext returns the remote cluster name stashed in ctx by
+// NewContextWithClusterName, if any.
+func ClusterNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(clusterNameContextKey{}).(string)
+	return name, ok
+}
+
+// ClusterNameFromRequest recovers the remote cluster name from a workqueue
+// item enqueued as a ClusterAwareRequest (e.g. by MeshGatewayProvider),
+// for Reconcilers engaged against more than one remote cluster that need
+// to know which cluster a dequeued request came from.
+func ClusterNameFromRequest(item interface{}) (string, bool) {
+	req, ok := item.(ClusterAwareRequest)
+	if !ok {
+		return "", false
+	}
+	return req.ClusterName, true
+}